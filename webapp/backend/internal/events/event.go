@@ -0,0 +1,52 @@
+package events
+
+import (
+	"context"
+	"time"
+)
+
+// EventType は注文ライフサイクル上で発生するドメインイベントの種類を表す。
+type EventType string
+
+const (
+	OrderCreated        EventType = "order.created"
+	OrderStatusChanged  EventType = "order.status_changed"
+	DeliveryPlanCreated EventType = "delivery_plan.created"
+)
+
+// Event は1件のドメインイベント。OrderID はKafka送出時のパーティションキーとして使い、
+// 注文単位でのイベント順序を保証する。注文に紐づかないイベント(ロボット単位の配送計画確定など)では nil になる。
+type Event struct {
+	Type       EventType
+	OrderID    *int64
+	Payload    interface{}
+	OccurredAt time.Time
+}
+
+// OrderCreatedPayload は OrderCreated イベントのペイロード。
+type OrderCreatedPayload struct {
+	OrderID   int64 `json:"order_id"`
+	UserID    int   `json:"user_id"`
+	ProductID int   `json:"product_id"`
+}
+
+// OrderStatusChangedPayload は OrderStatusChanged イベントのペイロード。
+type OrderStatusChangedPayload struct {
+	OrderID int64  `json:"order_id"`
+	From    string `json:"from"`
+	To      string `json:"to"`
+	RobotID string `json:"robot_id,omitempty"`
+}
+
+// DeliveryPlanCreatedPayload は DeliveryPlanCreated イベントのペイロード。
+type DeliveryPlanCreatedPayload struct {
+	RobotID    string  `json:"robot_id"`
+	OrderIDs   []int64 `json:"order_ids"`
+	TotalValue int     `json:"total_value"`
+}
+
+// Publisher はコミット後に発生したドメインイベントを送出する。
+// 実装は InMemoryPublisher(テスト用) と KafkaPublisher(本番用) の2種類を用意する。
+type Publisher interface {
+	Publish(ctx context.Context, event Event) error
+}
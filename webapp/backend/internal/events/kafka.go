@@ -0,0 +1,68 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// KafkaEnabled は ENABLE_KAFKA_LOG と同様のパターンで、Kafka へのイベント送出を有効にするか判定する。
+func KafkaEnabled() bool {
+	return os.Getenv("ENABLE_KAFKA_EVENTS") == "true"
+}
+
+// KafkaPublisher は EventType ごとに専用トピックを持ち、注文単位の順序を保つため
+// OrderID をパーティションキーに使って送出する。
+type KafkaPublisher struct {
+	writers map[EventType]*kafka.Writer
+}
+
+// NewKafkaPublisher は topicPrefix + "." + イベント種別 をトピック名として書き込む Writer を用意する。
+func NewKafkaPublisher(brokers []string, topicPrefix string) *KafkaPublisher {
+	types := []EventType{OrderCreated, OrderStatusChanged, DeliveryPlanCreated}
+	writers := make(map[EventType]*kafka.Writer, len(types))
+	for _, t := range types {
+		writers[t] = &kafka.Writer{
+			Addr:     kafka.TCP(brokers...),
+			Topic:    fmt.Sprintf("%s.%s", topicPrefix, t),
+			Balancer: &kafka.Hash{},
+		}
+	}
+	return &KafkaPublisher{writers: writers}
+}
+
+// Publish はイベントをJSONへシリアライズし、対応するトピックへ書き込む。
+func (p *KafkaPublisher) Publish(ctx context.Context, event Event) error {
+	writer, ok := p.writers[event.Type]
+	if !ok {
+		return fmt.Errorf("events: no kafka writer registered for %s", event.Type)
+	}
+
+	value, err := json.Marshal(event.Payload)
+	if err != nil {
+		return err
+	}
+
+	key := "fleet"
+	if event.OrderID != nil {
+		key = fmt.Sprintf("%d", *event.OrderID)
+	}
+
+	return writer.WriteMessages(ctx, kafka.Message{
+		Key:   []byte(key),
+		Value: value,
+	})
+}
+
+// Close は保持している全トピックの Writer を閉じる。
+func (p *KafkaPublisher) Close() error {
+	for _, w := range p.writers {
+		if err := w.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package events
+
+import (
+	"context"
+	"sync"
+)
+
+// InMemoryPublisher はテスト用のファンアウト実装。Publish されたイベントを蓄積しつつ、
+// 登録済みの各ハンドラへ同期的に配送する。
+type InMemoryPublisher struct {
+	mu       sync.Mutex
+	events   []Event
+	handlers []func(Event)
+}
+
+// NewInMemoryPublisher は空の InMemoryPublisher を生成する。
+func NewInMemoryPublisher() *InMemoryPublisher {
+	return &InMemoryPublisher{}
+}
+
+// Subscribe はイベント発行のたびに呼び出されるハンドラを登録する。
+func (p *InMemoryPublisher) Subscribe(handler func(Event)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.handlers = append(p.handlers, handler)
+}
+
+// Publish はイベントを記録し、登録済みハンドラへ配送する。
+func (p *InMemoryPublisher) Publish(ctx context.Context, event Event) error {
+	p.mu.Lock()
+	p.events = append(p.events, event)
+	handlers := append([]func(Event){}, p.handlers...)
+	p.mu.Unlock()
+
+	for _, handler := range handlers {
+		handler(event)
+	}
+	return nil
+}
+
+// Events はこれまでに発行されたイベントのスナップショットを返す。
+func (p *InMemoryPublisher) Events() []Event {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]Event{}, p.events...)
+}
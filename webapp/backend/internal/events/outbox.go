@@ -0,0 +1,130 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// OutboxRecord は event_outbox テーブル1行に対応する、まだ送出していないイベント。
+// RobotService/OrderRepository は ExecTx の中でこのテーブルへ Insert し、コミットと
+// イベント記録をアトミックにすることでプロセスがコミット後・送出前に落ちてもイベントを失わない。
+type OutboxRecord struct {
+	ID        int64
+	EventType EventType
+	OrderID   *int64
+	Payload   json.RawMessage
+	CreatedAt time.Time
+	Attempts  int
+}
+
+// OutboxStore は event_outbox テーブルへの読み書きを抽象化する。実装は repository.OrderRepository。
+type OutboxStore interface {
+	FetchPending(ctx context.Context, limit int) ([]OutboxRecord, error)
+	MarkDispatched(ctx context.Context, id int64) error
+	MarkFailed(ctx context.Context, id int64, attempts int) error
+}
+
+var (
+	outboxLag = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "order_events_outbox_lag",
+		Help: "Number of undispatched event_outbox rows fetched by the last drain (at most the dispatcher batch size).",
+	})
+	publishFailuresTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "order_events_publish_failures_total",
+		Help: "Total number of outbox publish attempts that failed after exhausting retries.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(outboxLag, publishFailuresTotal)
+}
+
+// Dispatcher はバックグラウンドで event_outbox をポーリングし、Publisher へ at-least-once で配送する。
+type Dispatcher struct {
+	store       OutboxStore
+	publisher   Publisher
+	pollEvery   time.Duration
+	batchSize   int
+	maxAttempts int
+}
+
+// NewDispatcher はデフォルトのポーリング間隔・バッチサイズ・リトライ回数で Dispatcher を作る。
+func NewDispatcher(store OutboxStore, publisher Publisher) *Dispatcher {
+	return &Dispatcher{
+		store:       store,
+		publisher:   publisher,
+		pollEvery:   1 * time.Second,
+		batchSize:   100,
+		maxAttempts: 5,
+	}
+}
+
+// Run は ctx がキャンセルされるまで event_outbox のドレインを繰り返す。呼び出し側が
+// 専用のgoroutineとして起動することを想定している。
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.pollEvery)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			d.drainOnce(ctx)
+		}
+	}
+}
+
+func (d *Dispatcher) drainOnce(ctx context.Context) {
+	records, err := d.store.FetchPending(ctx, d.batchSize)
+	if err != nil {
+		log.Printf("events: fetch pending outbox records: %v", err)
+		return
+	}
+	outboxLag.Set(float64(len(records)))
+
+	for _, record := range records {
+		if err := d.publishWithRetry(ctx, record); err != nil {
+			publishFailuresTotal.Inc()
+			log.Printf("events: giving up on outbox record %d after %d attempts: %v", record.ID, d.maxAttempts, err)
+			if markErr := d.store.MarkFailed(ctx, record.ID, record.Attempts+1); markErr != nil {
+				log.Printf("events: mark outbox record %d failed: %v", record.ID, markErr)
+			}
+			continue
+		}
+		if err := d.store.MarkDispatched(ctx, record.ID); err != nil {
+			log.Printf("events: mark outbox record %d dispatched: %v", record.ID, err)
+		}
+	}
+}
+
+// publishWithRetry は指数バックオフで最大 maxAttempts 回まで送出を試みる。
+func (d *Dispatcher) publishWithRetry(ctx context.Context, record OutboxRecord) error {
+	event := Event{
+		Type:       record.EventType,
+		OrderID:    record.OrderID,
+		Payload:    record.Payload,
+		OccurredAt: record.CreatedAt,
+	}
+
+	backoff := 100 * time.Millisecond
+	var lastErr error
+	for attempt := 0; attempt < d.maxAttempts; attempt++ {
+		if err := d.publisher.Publish(ctx, event); err != nil {
+			lastErr = err
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+			continue
+		}
+		return nil
+	}
+	return lastErr
+}
@@ -0,0 +1,101 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeOutboxStore is an in-memory OutboxStore stand-in for exercising Dispatcher.drainOnce
+// without a real database.
+type fakeOutboxStore struct {
+	mu         sync.Mutex
+	pending    []OutboxRecord
+	dispatched []int64
+	failed     map[int64]int
+}
+
+func newFakeOutboxStore(records ...OutboxRecord) *fakeOutboxStore {
+	return &fakeOutboxStore{pending: records, failed: make(map[int64]int)}
+}
+
+func (s *fakeOutboxStore) FetchPending(ctx context.Context, limit int) ([]OutboxRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if limit < len(s.pending) {
+		return append([]OutboxRecord{}, s.pending[:limit]...), nil
+	}
+	return append([]OutboxRecord{}, s.pending...), nil
+}
+
+func (s *fakeOutboxStore) MarkDispatched(ctx context.Context, id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.dispatched = append(s.dispatched, id)
+	return nil
+}
+
+func (s *fakeOutboxStore) MarkFailed(ctx context.Context, id int64, attempts int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failed[id] = attempts
+	return nil
+}
+
+// fakePublisher fails the first failCount calls (counted across all records) before
+// succeeding, or fails forever if failCount < 0.
+type fakePublisher struct {
+	mu        sync.Mutex
+	failCount int
+	calls     int
+}
+
+func (p *fakePublisher) Publish(ctx context.Context, event Event) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.calls++
+	if p.failCount < 0 || p.calls <= p.failCount {
+		return errors.New("fake publish failure")
+	}
+	return nil
+}
+
+func TestDispatcher_DrainOnce_MarksDispatchedOnSuccess(t *testing.T) {
+	store := newFakeOutboxStore(OutboxRecord{ID: 1, EventType: OrderCreated, Payload: json.RawMessage(`{}`)})
+	publisher := &fakePublisher{failCount: 0}
+	d := &Dispatcher{store: store, publisher: publisher, batchSize: 10, maxAttempts: 3}
+
+	d.drainOnce(context.Background())
+
+	if len(store.dispatched) != 1 || store.dispatched[0] != 1 {
+		t.Fatalf("expected record 1 to be marked dispatched, got %v", store.dispatched)
+	}
+	if len(store.failed) != 0 {
+		t.Fatalf("expected no failed records, got %v", store.failed)
+	}
+}
+
+func TestDispatcher_DrainOnce_GivesUpAfterMaxAttempts(t *testing.T) {
+	store := newFakeOutboxStore(OutboxRecord{ID: 7, EventType: OrderStatusChanged, Payload: json.RawMessage(`{}`), Attempts: 1})
+	publisher := &fakePublisher{failCount: -1}
+	d := &Dispatcher{store: store, publisher: publisher, batchSize: 10, maxAttempts: 2}
+
+	start := time.Now()
+	d.drainOnce(context.Background())
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("drainOnce took unexpectedly long: %v", elapsed)
+	}
+
+	if len(store.dispatched) != 0 {
+		t.Fatalf("expected no records marked dispatched, got %v", store.dispatched)
+	}
+	if attempts, ok := store.failed[7]; !ok || attempts != 2 {
+		t.Fatalf("expected record 7 marked failed with attempts=2, got %v (present=%v)", attempts, ok)
+	}
+	if publisher.calls != d.maxAttempts {
+		t.Fatalf("expected %d publish attempts, got %d", d.maxAttempts, publisher.calls)
+	}
+}
@@ -0,0 +1,35 @@
+package model
+
+import "time"
+
+// SortSpec は一覧系クエリの ORDER BY 1キー分を表す。Field/Direction はどちらも
+// リポジトリ側のホワイトリストで検証されてからSQLに反映される。
+type SortSpec struct {
+	Field     string
+	Direction string
+}
+
+// ListRequest は一覧系APIの検索・絞り込み・ソート・ページングをまとめて表す共通リクエスト。
+type ListRequest struct {
+	Search string
+	Type   string
+
+	// SortField/SortOrder は単一キーソートのみをサポートするリポジトリ(ProductRepositoryなど)向け。
+	SortField string
+	SortOrder string
+
+	// Sorts は複数キーソートをサポートするリポジトリ(OrderRepository.ListOrders)向け。
+	// 決定的なタイブレーカーのカラムが含まれていなければ末尾へ追加される。
+	Sorts []SortSpec
+
+	PageSize int
+	Offset   int
+
+	// 注文履歴の絞り込み条件。nilまたは空の場合はその条件を適用しない。
+	ShippedStatuses []string
+	OrderID         *int64
+	CreatedAtFrom   *time.Time
+	CreatedAtTo     *time.Time
+	ArrivedAtFrom   *time.Time
+	ArrivedAtTo     *time.Time
+}
@@ -0,0 +1,40 @@
+package model
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Order は注文1件を表すドメインモデル。取得元のクエリによって埋まるフィールドが異なる
+// (配送ロボット向けの取得では Weight/Value のみ、履歴一覧では ProductName 等も含む)。
+type Order struct {
+	OrderID       int64        `db:"order_id"`
+	UserID        int          `db:"user_id"`
+	ProductID     int          `db:"product_id"`
+	ProductName   string       `db:"product_name"`
+	ShippedStatus string       `db:"shipped_status"`
+	Weight        int          `db:"weight"`
+	Value         int          `db:"value"`
+	CreatedAt     time.Time    `db:"created_at"`
+	ArrivedAt     sql.NullTime `db:"arrived_at"`
+
+	// CancelCount は delivering から shipping へ差し戻された回数。閾値に達するキャンセルでは差し戻さず failed へ遷移する。
+	CancelCount int `db:"cancel_count"`
+	// LastRobotID はこの注文を最後に扱ったロボットのID。差し戻し・失敗調査の追跡に使う。
+	LastRobotID string `db:"last_robot_id"`
+	// StatusUpdatedAt は shipped_status が最後に変わった時刻。stuck 注文の強制リセット判定に使う。
+	StatusUpdatedAt sql.NullTime `db:"status_updated_at"`
+
+	// Priority は注文の優先度。knapsack の有効価値計算で高いほど重み付けされる。
+	Priority int `db:"priority"`
+	// DeadlineAt はこの注文を配送しなければならない期限。未設定ならSLAなし。
+	DeadlineAt sql.NullTime `db:"deadline_at"`
+}
+
+// DeliveryPlan は1台のロボットに割り当てられた配送計画。
+type DeliveryPlan struct {
+	RobotID     string  `json:"robot_id"`
+	TotalWeight int     `json:"total_weight"`
+	TotalValue  int     `json:"total_value"`
+	Orders      []Order `json:"orders"`
+}
@@ -0,0 +1,11 @@
+package model
+
+// Product は商品カタログ1件を表すドメインモデル。
+type Product struct {
+	ProductID   int    `db:"product_id" json:"product_id"`
+	Name        string `db:"name" json:"name"`
+	Value       int    `db:"value" json:"value"`
+	Weight      int    `db:"weight" json:"weight"`
+	Image       string `db:"image" json:"image"`
+	Description string `db:"description" json:"description"`
+}
@@ -1,15 +1,27 @@
 package repository
 
 import (
+	"backend/internal/events"
 	"backend/internal/model"
 	"context"
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/jmoiron/sqlx"
 )
 
+// orderSortColumns は ListOrders が並び替えを許可するフィールド名から実際のSQLカラムへの対応表。
+var orderSortColumns = map[string]string{
+	"order_id":       "o.order_id",
+	"product_name":   "p.name",
+	"created_at":     "o.created_at",
+	"shipped_status": "o.shipped_status",
+	"arrived_at":     "o.arrived_at",
+}
+
 type OrderRepository struct {
 	db DBTX
 }
@@ -18,7 +30,8 @@ func NewOrderRepository(db DBTX) *OrderRepository {
 	return &OrderRepository{db: db}
 }
 
-// 注文を作成し、生成された注文IDを返す
+// 注文を作成し、生成された注文IDを返す。作成成功後、同一トランザクション内で
+// OrderCreated イベントをアウトボックスへ書き込む。
 func (r *OrderRepository) Create(ctx context.Context, order *model.Order) (string, error) {
 	query := `INSERT INTO orders (user_id, product_id, shipped_status, created_at) VALUES (?, ?, 'shipping', NOW())`
 	result, err := r.db.ExecContext(ctx, query, order.UserID, order.ProductID)
@@ -29,21 +42,176 @@ func (r *OrderRepository) Create(ctx context.Context, order *model.Order) (strin
 	if err != nil {
 		return "", err
 	}
+
+	if err := r.writeOutbox(ctx, events.OrderCreated, &id, events.OrderCreatedPayload{
+		OrderID:   id,
+		UserID:    order.UserID,
+		ProductID: order.ProductID,
+	}); err != nil {
+		return "", err
+	}
+
 	return fmt.Sprintf("%d", id), nil
 }
 
-// 複数の注文IDのステータスを一括で更新
-// 主に配送ロボットが注文を引き受けた際に一括更新をするために使用
-func (r *OrderRepository) UpdateStatuses(ctx context.Context, orderIDs []int64, newStatus string) error {
+// TransitionStatus は shipped_status が from の注文だけを to へ遷移させる唯一の書き込み経路。
+// 候補行は呼び出し元の ExecTx 内で SELECT ... FOR UPDATE ロックし、TOCTOU を防ぐ。
+// robotID=="" (管理者経由の呼び出し)では last_robot_id を上書きせず、cancel_count も進めない。
+// 戻り値は実際に遷移できた orderID の一覧。
+func (r *OrderRepository) TransitionStatus(ctx context.Context, orderIDs []int64, from, to string, robotID string) ([]int64, error) {
 	if len(orderIDs) == 0 {
-		return nil
+		return nil, nil
+	}
+
+	selectQuery, selectArgs, err := sqlx.In(
+		"SELECT order_id FROM orders WHERE order_id IN (?) AND shipped_status = ? FOR UPDATE", orderIDs, from,
+	)
+	if err != nil {
+		return nil, err
+	}
+	selectQuery = r.db.Rebind(selectQuery)
+	var transitioning []int64
+	if err := r.db.SelectContext(ctx, &transitioning, selectQuery, selectArgs...); err != nil {
+		return nil, err
+	}
+	if len(transitioning) == 0 {
+		return nil, nil
+	}
+
+	updateSQL := "UPDATE orders SET shipped_status = ?, status_updated_at = NOW()"
+	args := []interface{}{to}
+	if robotID != "" {
+		updateSQL += ", last_robot_id = ?"
+		args = append(args, robotID)
+		if from == "delivering" && to == "shipping" {
+			updateSQL += ", cancel_count = cancel_count + 1"
+		}
+	}
+	updateSQL += " WHERE order_id IN (?) AND shipped_status = ?"
+	args = append(args, transitioning, from)
+
+	updateQuery, updateArgs, err := sqlx.In(updateSQL, args...)
+	if err != nil {
+		return nil, err
+	}
+	updateQuery = r.db.Rebind(updateQuery)
+	if _, err := r.db.ExecContext(ctx, updateQuery, updateArgs...); err != nil {
+		return nil, err
+	}
+
+	for _, orderID := range transitioning {
+		orderID := orderID
+		if err := r.writeOutbox(ctx, events.OrderStatusChanged, &orderID, events.OrderStatusChangedPayload{
+			OrderID: orderID,
+			From:    from,
+			To:      to,
+			RobotID: robotID,
+		}); err != nil {
+			return nil, err
+		}
+	}
+
+	return transitioning, nil
+}
+
+// GetCancelCount は注文の現在の cancel_count を返す。CancelDelivery が failed への強制遷移を
+// 判断するために使う。
+func (r *OrderRepository) GetCancelCount(ctx context.Context, orderID int64) (int, error) {
+	var cancelCount int
+	err := r.db.GetContext(ctx, &cancelCount, "SELECT cancel_count FROM orders WHERE order_id = ?", orderID)
+	return cancelCount, err
+}
+
+// ForceResetStuckOrders は delivering のまま threshold より長く放置されている注文を shipping へ
+// 強制的に差し戻す。ロボットが応答不能になったまま注文を持ち続けるケースの救済用で、
+// 管理者向けエンドポイントから呼び出されることを想定している。
+func (r *OrderRepository) ForceResetStuckOrders(ctx context.Context, olderThan time.Duration) ([]int64, error) {
+	var stuck []int64
+	query := `
+        SELECT order_id FROM orders
+        WHERE shipped_status = 'delivering' AND status_updated_at < ?
+    `
+	threshold := time.Now().Add(-olderThan)
+	if err := r.db.SelectContext(ctx, &stuck, query, threshold); err != nil {
+		return nil, err
+	}
+	if len(stuck) == 0 {
+		return nil, nil
 	}
-	query, args, err := sqlx.In("UPDATE orders SET shipped_status = ? WHERE order_id IN (?)", newStatus, orderIDs)
+	return r.TransitionStatus(ctx, stuck, "delivering", "shipping", "")
+}
+
+// WriteDeliveryPlanEvent は1台のロボットに対して確定した配送計画のイベントをアウトボックスへ書き込む。
+// 注文単位のイベントではないため OrderID は付与しない。
+func (r *OrderRepository) WriteDeliveryPlanEvent(ctx context.Context, robotID string, orderIDs []int64, totalValue int) error {
+	return r.writeOutbox(ctx, events.DeliveryPlanCreated, nil, events.DeliveryPlanCreatedPayload{
+		RobotID:    robotID,
+		OrderIDs:   orderIDs,
+		TotalValue: totalValue,
+	})
+}
+
+// writeOutbox は event_outbox テーブルへ1行挿入する。呼び出し元のExecTxと同一トランザクションに
+// 乗るため、コミット後にバックグラウンドの Dispatcher が確実にこの行を拾って送出できる。
+func (r *OrderRepository) writeOutbox(ctx context.Context, eventType events.EventType, orderID *int64, payload interface{}) error {
+	data, err := json.Marshal(payload)
 	if err != nil {
 		return err
 	}
-	query = r.db.Rebind(query)
-	_, err = r.db.ExecContext(ctx, query, args...)
+	query := `INSERT INTO event_outbox (event_type, order_id, payload, created_at) VALUES (?, ?, ?, NOW())`
+	_, err = r.db.ExecContext(ctx, query, string(eventType), orderID, data)
+	return err
+}
+
+// FetchPending は events.Dispatcher が events.OutboxStore として使う読み出し経路。
+// dispatched_at がまだ立っていない行を挿入順(= id の昇順)に最大 limit 件返す。
+func (r *OrderRepository) FetchPending(ctx context.Context, limit int) ([]events.OutboxRecord, error) {
+	type outboxRow struct {
+		ID        int64           `db:"id"`
+		EventType string          `db:"event_type"`
+		OrderID   *int64          `db:"order_id"`
+		Payload   json.RawMessage `db:"payload"`
+		CreatedAt time.Time       `db:"created_at"`
+		Attempts  int             `db:"attempts"`
+	}
+
+	var rows []outboxRow
+	query := `
+        SELECT id, event_type, order_id, payload, created_at, attempts
+        FROM event_outbox
+        WHERE dispatched_at IS NULL
+        ORDER BY id ASC
+        LIMIT ?
+    `
+	if err := r.db.SelectContext(ctx, &rows, query, limit); err != nil {
+		return nil, err
+	}
+
+	records := make([]events.OutboxRecord, len(rows))
+	for i, row := range rows {
+		records[i] = events.OutboxRecord{
+			ID:        row.ID,
+			EventType: events.EventType(row.EventType),
+			OrderID:   row.OrderID,
+			Payload:   row.Payload,
+			CreatedAt: row.CreatedAt,
+			Attempts:  row.Attempts,
+		}
+	}
+	return records, nil
+}
+
+// MarkDispatched は Publish に成功した行を dispatched_at を埋めて完了させ、以降の
+// FetchPending の対象から外す。
+func (r *OrderRepository) MarkDispatched(ctx context.Context, id int64) error {
+	_, err := r.db.ExecContext(ctx, "UPDATE event_outbox SET dispatched_at = NOW() WHERE id = ?", id)
+	return err
+}
+
+// MarkFailed は maxAttempts 回の送出リトライを使い切った行の attempts を更新する。
+// dispatched_at は立てないため、その行は次回の FetchPending でも再びポーリング対象になる。
+func (r *OrderRepository) MarkFailed(ctx context.Context, id int64, attempts int) error {
+	_, err := r.db.ExecContext(ctx, "UPDATE event_outbox SET attempts = ? WHERE id = ?", attempts, id)
 	return err
 }
 
@@ -54,7 +222,9 @@ func (r *OrderRepository) GetShippingOrders(ctx context.Context) ([]model.Order,
         SELECT
             o.order_id,
             p.weight,
-            p.value
+            p.value,
+            o.priority,
+            o.deadline_at
         FROM orders o
         JOIN products p ON o.product_id = p.product_id
         WHERE o.shipped_status = 'shipping'
@@ -67,18 +237,18 @@ func (r *OrderRepository) GetShippingOrders(ctx context.Context) ([]model.Order,
 func (r *OrderRepository) ListOrders(ctx context.Context, userID int, req model.ListRequest) ([]model.Order, int, error) {
 	// 基本のJOINクエリ - N+1問題を解決
 	baseQuery := `
-        SELECT 
-            o.order_id, 
-            o.product_id, 
+        SELECT
+            o.order_id,
+            o.product_id,
             p.name as product_name,
-            o.shipped_status, 
-            o.created_at, 
+            o.shipped_status,
+            o.created_at,
             o.arrived_at
-        FROM orders o 
+        FROM orders o
         JOIN products p ON o.product_id = p.product_id
         WHERE o.user_id = ?`
 
-	// 検索条件をSQLで処理
+	// 検索・絞り込み条件をSQLで処理
 	var conditions []string
 	var args []interface{}
 	args = append(args, userID)
@@ -93,55 +263,72 @@ func (r *OrderRepository) ListOrders(ctx context.Context, userID int, req model.
 		}
 	}
 
-	if len(conditions) > 0 {
-		baseQuery += " AND " + strings.Join(conditions, " AND ")
+	if len(req.ShippedStatuses) > 0 {
+		conditions = append(conditions, "o.shipped_status IN (?)")
+		args = append(args, req.ShippedStatuses)
 	}
 
-	// ソート条件をSQLで処理
-	orderClause := " ORDER BY "
-	switch req.SortField {
-	case "product_name":
-		orderClause += "p.name"
-	case "created_at":
-		orderClause += "o.created_at"
-	case "shipped_status":
-		orderClause += "o.shipped_status"
-	case "arrived_at":
-		orderClause += "o.arrived_at"
-	case "order_id":
-		fallthrough
-	default:
-		orderClause += "o.order_id"
+	if req.OrderID != nil {
+		conditions = append(conditions, "o.order_id = ?")
+		args = append(args, *req.OrderID)
+	}
+
+	if req.CreatedAtFrom != nil {
+		conditions = append(conditions, "o.created_at >= ?")
+		args = append(args, *req.CreatedAtFrom)
+	}
+	if req.CreatedAtTo != nil {
+		conditions = append(conditions, "o.created_at <= ?")
+		args = append(args, *req.CreatedAtTo)
+	}
+	if req.ArrivedAtFrom != nil {
+		conditions = append(conditions, "o.arrived_at >= ?")
+		args = append(args, *req.ArrivedAtFrom)
+	}
+	if req.ArrivedAtTo != nil {
+		conditions = append(conditions, "o.arrived_at <= ?")
+		args = append(args, *req.ArrivedAtTo)
 	}
 
-	if strings.ToUpper(req.SortOrder) == "DESC" {
-		orderClause += " DESC"
-	} else {
-		orderClause += " ASC"
+	if len(conditions) > 0 {
+		baseQuery += " AND " + strings.Join(conditions, " AND ")
 	}
 
-	// 件数取得用のクエリ
+	// 件数取得用のクエリ。ページングとソートを除き、絞り込み条件を共有する。
 	countQuery := `
-        SELECT COUNT(*) 
-        FROM orders o 
-        JOIN products p ON o.product_id = p.product_id 
+        SELECT COUNT(*)
+        FROM orders o
+        JOIN products p ON o.product_id = p.product_id
         WHERE o.user_id = ?`
+	countArgs := append([]interface{}{}, args...)
+	if len(conditions) > 0 {
+		countQuery += " AND " + strings.Join(conditions, " AND ")
+	}
 
-	countArgs := []interface{}{userID}
-	if req.Search != "" {
-		if req.Type == "prefix" {
-			countQuery += " AND p.name LIKE ?"
-			countArgs = append(countArgs, req.Search+"%")
-		} else {
-			countQuery += " AND p.name LIKE ?"
-			countArgs = append(countArgs, "%"+req.Search+"%")
-		}
+	// ソート条件はホワイトリストで検証してから組み立てる。複数キーを指定でき、
+	// order_id がソートキーに含まれていなければ order_id ASC をタイブレーカーとして末尾へ付与するので、同値が並ぶ場合でも
+	// ページネーションが安定する。
+	orderByClause, err := buildOrderByClause(req.Sorts, orderSortColumns, "o.order_id")
+	if err != nil {
+		return nil, 0, err
 	}
 
 	// ページネーション
-	dataQuery := baseQuery + orderClause + " LIMIT ? OFFSET ?"
+	dataQuery := baseQuery + " " + orderByClause + " LIMIT ? OFFSET ?"
 	args = append(args, req.PageSize, req.Offset)
 
+	dataQuery, dataArgs, err := sqlx.In(dataQuery, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	dataQuery = r.db.Rebind(dataQuery)
+
+	countQuery, countInArgs, err := sqlx.In(countQuery, countArgs...)
+	if err != nil {
+		return nil, 0, err
+	}
+	countQuery = r.db.Rebind(countQuery)
+
 	// データ構造の定義
 	type orderRow struct {
 		OrderID       int          `db:"order_id"`
@@ -156,11 +343,11 @@ func (r *OrderRepository) ListOrders(ctx context.Context, userID int, req model.
 	var total int
 
 	// データと件数を取得
-	if err := r.db.SelectContext(ctx, &ordersRaw, dataQuery, args...); err != nil {
+	if err := r.db.SelectContext(ctx, &ordersRaw, dataQuery, dataArgs...); err != nil {
 		return nil, 0, err
 	}
 
-	if err := r.db.GetContext(ctx, &total, countQuery, countArgs...); err != nil {
+	if err := r.db.GetContext(ctx, &total, countQuery, countInArgs...); err != nil {
 		return nil, 0, err
 	}
 
@@ -0,0 +1,49 @@
+package repository
+
+import (
+	"backend/internal/model"
+	"fmt"
+	"strings"
+)
+
+// allowedSortDirections はSQLに埋め込む前に検証するソート方向のホワイトリスト。
+// 未知の方向はフィールド同様エラーとして拒否する。
+var allowedSortDirections = map[string]string{
+	"ASC":  "ASC",
+	"DESC": "DESC",
+}
+
+// buildOrderByClause はホワイトリストに載っているカラムだけを使って ORDER BY 句を組み立てる。
+// ProductRepository / OrderRepository はともにこのヘルパーを経由してソート条件をSQLへ反映し、
+// req.SortField を直接連結していた旧実装のSQLインジェクション経路を塞ぐ。
+// tieBreaker は複数行が同値でも安定したページネーションになるよう、sorts にまだ含まれていなければ末尾へ追加される。
+func buildOrderByClause(sorts []model.SortSpec, allowed map[string]string, tieBreaker string) (string, error) {
+	var clauses []string
+	seen := make(map[string]bool)
+
+	for _, s := range sorts {
+		col, ok := allowed[s.Field]
+		if !ok {
+			return "", fmt.Errorf("repository: invalid sort field %q", s.Field)
+		}
+		if seen[col] {
+			continue
+		}
+		direction := s.Direction
+		if direction == "" {
+			direction = "ASC"
+		}
+		dir, ok := allowedSortDirections[strings.ToUpper(direction)]
+		if !ok {
+			return "", fmt.Errorf("repository: invalid sort direction %q", s.Direction)
+		}
+		seen[col] = true
+		clauses = append(clauses, col+" "+dir)
+	}
+
+	if !seen[tieBreaker] {
+		clauses = append(clauses, tieBreaker+" ASC")
+	}
+
+	return "ORDER BY " + strings.Join(clauses, ", "), nil
+}
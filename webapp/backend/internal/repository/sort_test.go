@@ -0,0 +1,43 @@
+package repository
+
+import (
+	"backend/internal/model"
+	"strings"
+	"testing"
+)
+
+func TestBuildOrderByClause_BlankDirectionDefaultsToASC(t *testing.T) {
+	clause, err := buildOrderByClause(
+		[]model.SortSpec{{Field: "name", Direction: ""}},
+		productSortColumns,
+		"product_id",
+	)
+	if err != nil {
+		t.Fatalf("buildOrderByClause returned error: %v", err)
+	}
+	if !strings.Contains(clause, "name ASC") {
+		t.Fatalf("expected blank direction to default to ASC, got %q", clause)
+	}
+}
+
+func TestBuildOrderByClause_RejectsUnrecognizedDirection(t *testing.T) {
+	_, err := buildOrderByClause(
+		[]model.SortSpec{{Field: "name", Direction: "; DROP TABLE products"}},
+		productSortColumns,
+		"product_id",
+	)
+	if err == nil {
+		t.Fatal("expected an error for an unrecognized sort direction, got nil")
+	}
+}
+
+func TestBuildOrderByClause_RejectsUnrecognizedField(t *testing.T) {
+	_, err := buildOrderByClause(
+		[]model.SortSpec{{Field: "; DROP TABLE products", Direction: "ASC"}},
+		productSortColumns,
+		"product_id",
+	)
+	if err == nil {
+		t.Fatal("expected an error for an unrecognized sort field, got nil")
+	}
+}
@@ -5,16 +5,418 @@ import (
 	"backend/internal/repository"
 	"backend/internal/service/utils"
 	"context"
+	"database/sql"
 	"log"
+	"math"
 	"sort"
+	"sync"
+	"time"
+)
+
+// fleet バッチ処理のデバウンス窓。この間に届いたロボットのリクエストを1回のMKP解に束ねる。
+const fleetDebounceWindow = 150 * time.Millisecond
+
+// MKPのローカルサーチ改善パスに許容する最大時間。
+const fleetLocalSearchBudget = 100 * time.Millisecond
+
+// selectOrdersForDeliveryOptimized が exact DP を諦める閾値を超えたときに使うFPTASの既定ε。
+// (1-ε)・OPT を保証する近似精度で、小さいほど精度が上がり計算量も増える。
+const defaultKnapsackEpsilon = 0.1
+
+// maxFPTASWidth は selectOrdersFPTAS のDP幅(maxScaledValue)に許容する上限。超える場合は
+// 注文を足切りせず、スケーリング係数 K を粗くして幅を抑える。その場合の誤差上限は ε・OPT ではなく
+// (n²/maxFPTASWidth)・OPT になり、n > sqrt(ε・maxFPTASWidth)(既定 ε=0.1 では n > 800)で ε を超える。
+const maxFPTASWidth = 6_400_000
+
+// V_eff = Value * (1 + alpha*priority) + beta*urgency(deadline_at, now) の既定係数。
+const (
+	defaultPriorityAlpha  = 0.1
+	defaultDeadlineBeta   = 50.0
+	defaultUrgencyWindow  = 24 * time.Hour
+	defaultMustShipWindow = 1 * time.Hour
 )
 
 type RobotService struct {
-	store *repository.Store
+	store   *repository.Store
+	epsilon float64
+
+	// priorityAlpha/deadlineBeta/urgencyWindow は selectOrdersDP/selectOrdersFPTAS が使う
+	// 有効価値 V_eff の重み。mustShipWindow は期限切れ・期限直前の注文を強制的に積み込む
+	// hard-inclusion パスのしきい値。
+	priorityAlpha  float64
+	deadlineBeta   float64
+	urgencyWindow  time.Duration
+	mustShipWindow time.Duration
+
+	fleetOnce sync.Once
+	fleetCh   chan *fleetRequest
 }
 
 func NewRobotService(store *repository.Store) *RobotService {
-	return &RobotService{store: store}
+	return &RobotService{
+		store:          store,
+		epsilon:        defaultKnapsackEpsilon,
+		priorityAlpha:  defaultPriorityAlpha,
+		deadlineBeta:   defaultDeadlineBeta,
+		urgencyWindow:  defaultUrgencyWindow,
+		mustShipWindow: defaultMustShipWindow,
+	}
+}
+
+// SetEpsilon は selectOrdersForDeliveryOptimized が使うFPTASの近似精度εを上書きする。
+// ε が0以下の場合は既定値にフォールバックする。
+func (s *RobotService) SetEpsilon(epsilon float64) {
+	if epsilon <= 0 {
+		epsilon = defaultKnapsackEpsilon
+	}
+	s.epsilon = epsilon
+}
+
+// SetSLAWeights は優先度・締切に基づく有効価値 V_eff の係数と hard-inclusion のしきい値を上書きする。
+// urgencyWindow/mustShipWindow が0以下の場合は既定値にフォールバックする。
+func (s *RobotService) SetSLAWeights(alpha, beta float64, urgencyWindow, mustShipWindow time.Duration) {
+	s.priorityAlpha = alpha
+	s.deadlineBeta = beta
+	if urgencyWindow <= 0 {
+		urgencyWindow = defaultUrgencyWindow
+	}
+	if mustShipWindow <= 0 {
+		mustShipWindow = defaultMustShipWindow
+	}
+	s.urgencyWindow = urgencyWindow
+	s.mustShipWindow = mustShipWindow
+}
+
+// fleetRequest は GenerateFleetPlan から fleet コーディネーターに送られる1ロボット分の要求。
+type fleetRequest struct {
+	ctx      context.Context
+	robotID  string
+	capacity int
+	priority int
+	resultCh chan fleetResult
+}
+
+type fleetResult struct {
+	plan model.DeliveryPlan
+	err  error
+}
+
+// GenerateFleetPlan は複数ロボットからの同時リクエストを短いデバウンス窓でまとめ、
+// Multiple Knapsack Problem として一括で解くことで、GenerateDeliveryPlan が個別に
+// DPを解いた際に起きていた「複数ロボットが同じ注文を奪い合う」競合を避ける。
+// priority の高いロボットほど自身のキャパシティを優先的に埋められる。
+func (s *RobotService) GenerateFleetPlan(ctx context.Context, robotID string, capacity int, priority int) (*model.DeliveryPlan, error) {
+	s.fleetOnce.Do(func() {
+		s.fleetCh = make(chan *fleetRequest)
+		go s.runFleetCoordinator()
+	})
+
+	req := &fleetRequest{
+		ctx:      ctx,
+		robotID:  robotID,
+		capacity: capacity,
+		priority: priority,
+		resultCh: make(chan fleetResult, 1),
+	}
+
+	select {
+	case s.fleetCh <- req:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	select {
+	case res := <-req.resultCh:
+		if res.err != nil {
+			return nil, res.err
+		}
+		return &res.plan, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// runFleetCoordinator はロボットからのリクエストをデバウンス窓の間だけ蓄積し、
+// 窓が閉じたタイミングで1バッチとしてMKPを解く。RobotService ごとに1つだけ起動される。
+func (s *RobotService) runFleetCoordinator() {
+	var pending []*fleetRequest
+	var timer *time.Timer
+
+	for {
+		var timerC <-chan time.Time
+		if timer != nil {
+			timerC = timer.C
+		}
+
+		select {
+		case req := <-s.fleetCh:
+			pending = append(pending, req)
+			if timer == nil {
+				timer = time.NewTimer(fleetDebounceWindow)
+			}
+		case <-timerC:
+			batch := pending
+			pending = nil
+			timer = nil
+			go s.processFleetBatch(batch)
+		}
+	}
+}
+
+// fleetAssignment は1ロボットに割り当てられた注文の集合と、その重量・価値の累計を保持する。
+type fleetAssignment struct {
+	req    *fleetRequest
+	orders []model.Order
+	weight int
+	value  int
+}
+
+// processFleetBatch はバッチ内の全ロボットに対して GetShippingOrders のスナップショットを
+// 1回だけ取得し、Multiple Knapsack Problem を解いたうえでロボットごとに TransitionStatus を1回発行する。
+func (s *RobotService) processFleetBatch(batch []*fleetRequest) {
+	if len(batch) == 0 {
+		return
+	}
+
+	ctx := context.Background()
+
+	orders, err := s.store.OrderRepo.GetShippingOrders(ctx)
+	if err != nil {
+		broadcastFleetError(batch, err)
+		return
+	}
+
+	assignments := solveMultipleKnapsack(orders, batch)
+
+	for _, a := range assignments {
+		a := a
+
+		// リクエスト元が既にタイムアウト/キャンセルしている場合、ここで TransitionStatus を
+		// 発行しても呼び出し元はもう結果を受け取れない。該当ロボットへの割り当てはコミットせず
+		// 注文を shipping のまま残すことで、次のバッチで別のロボットが拾えるようにする。
+		if err := a.req.ctx.Err(); err != nil {
+			a.req.resultCh <- fleetResult{err: err}
+			continue
+		}
+
+		plan := model.DeliveryPlan{
+			RobotID:     a.req.robotID,
+			TotalWeight: a.weight,
+			TotalValue:  a.value,
+			Orders:      a.orders,
+		}
+
+		if len(a.orders) > 0 {
+			orderIDs := make([]int64, len(a.orders))
+			for i, order := range a.orders {
+				orderIDs[i] = order.OrderID
+			}
+			var transitioned []int64
+			err := s.store.ExecTx(ctx, func(txStore *repository.Store) error {
+				var err error
+				// shipping -> delivering の遷移のみを許可することで、fleet の外から
+				// (GenerateDeliveryPlan 経由などで)同じ注文が既に持ち去られていた場合は
+				// ここで自然に弾かれる。
+				transitioned, err = txStore.OrderRepo.TransitionStatus(ctx, orderIDs, "shipping", "delivering", a.req.robotID)
+				if err != nil {
+					return err
+				}
+				return txStore.OrderRepo.WriteDeliveryPlanEvent(ctx, a.req.robotID, transitioned, plan.TotalValue)
+			})
+			if err != nil {
+				a.req.resultCh <- fleetResult{err: err}
+				continue
+			}
+			plan.Orders = filterOrdersByID(a.orders, transitioned)
+			plan.TotalWeight, plan.TotalValue = sumWeightAndValue(plan.Orders)
+			log.Printf("Robot %s: fleet plan selected %d orders (weight: %d, value: %d)",
+				a.req.robotID, len(plan.Orders), plan.TotalWeight, plan.TotalValue)
+		}
+
+		a.req.resultCh <- fleetResult{plan: plan}
+	}
+}
+
+// filterOrdersByID は orders のうち、id が keepIDs に含まれるものだけを返す。
+// TransitionStatus が実際に遷移できた注文だけに配送計画を絞り込むために使う。
+func filterOrdersByID(orders []model.Order, keepIDs []int64) []model.Order {
+	keep := make(map[int64]bool, len(keepIDs))
+	for _, id := range keepIDs {
+		keep[id] = true
+	}
+	filtered := make([]model.Order, 0, len(keepIDs))
+	for _, order := range orders {
+		if keep[order.OrderID] {
+			filtered = append(filtered, order)
+		}
+	}
+	return filtered
+}
+
+func sumWeightAndValue(orders []model.Order) (int, int) {
+	weight, value := 0, 0
+	for _, order := range orders {
+		weight += order.Weight
+		value += order.Value
+	}
+	return weight, value
+}
+
+func broadcastFleetError(batch []*fleetRequest, err error) {
+	for _, req := range batch {
+		req.resultCh <- fleetResult{err: err}
+	}
+}
+
+// solveMultipleKnapsack は value/weight 比の降順に注文を走査し、その時点で収まる余力が
+// 最も大きいロボット(優先度の高いロボットを優先)へ貪欲に割り当てたうえで、未割り当て注文の
+// 追加と追い出し置換によるローカルサーチで合計価値を改善する(ペア間のアイテム交換も試すが、
+// 交換では合計価値が変わらないため採用されることはない)。
+func solveMultipleKnapsack(orders []model.Order, batch []*fleetRequest) []*fleetAssignment {
+	assignments := make([]*fleetAssignment, len(batch))
+	for i, req := range batch {
+		assignments[i] = &fleetAssignment{req: req}
+	}
+
+	sortedOrders := make([]model.Order, len(orders))
+	copy(sortedOrders, orders)
+	sort.Slice(sortedOrders, func(i, j int) bool {
+		ratioI := float64(sortedOrders[i].Value) / float64(sortedOrders[i].Weight)
+		ratioJ := float64(sortedOrders[j].Value) / float64(sortedOrders[j].Weight)
+		return ratioI > ratioJ
+	})
+
+	for _, order := range sortedOrders {
+		best := bestFleetCandidate(assignments, order)
+		if best == nil {
+			continue
+		}
+		best.orders = append(best.orders, order)
+		best.weight += order.Weight
+		best.value += order.Value
+	}
+
+	improveFleetAssignments(assignments, sortedOrders, time.Now().Add(fleetLocalSearchBudget))
+
+	return assignments
+}
+
+// bestFleetCandidate は注文が収まるロボットのうち、優先度が最も高く、次に残キャパシティが
+// 最も大きいものを選ぶ。「priority の高いロボットのキャパシティを優先的に埋める」という要件を満たす。
+func bestFleetCandidate(assignments []*fleetAssignment, order model.Order) *fleetAssignment {
+	var best *fleetAssignment
+	for _, a := range assignments {
+		remaining := a.req.capacity - a.weight
+		if remaining < order.Weight {
+			continue
+		}
+		if best == nil {
+			best = a
+			continue
+		}
+		if a.req.priority != best.req.priority {
+			if a.req.priority > best.req.priority {
+				best = a
+			}
+			continue
+		}
+		if remaining > best.req.capacity-best.weight {
+			best = a
+		}
+	}
+	return best
+}
+
+// improveFleetAssignments はロボット間でのアイテム交換、および未割り当て注文による
+// 追い出し置換を、改善が見られなくなるか時間予算が切れるまで繰り返す。
+func improveFleetAssignments(assignments []*fleetAssignment, allOrders []model.Order, deadline time.Time) {
+	assigned := make(map[int64]bool)
+	for _, a := range assignments {
+		for _, o := range a.orders {
+			assigned[o.OrderID] = true
+		}
+	}
+	var unassigned []model.Order
+	for _, o := range allOrders {
+		if !assigned[o.OrderID] {
+			unassigned = append(unassigned, o)
+		}
+	}
+
+	improved := true
+	for improved && time.Now().Before(deadline) {
+		improved = false
+
+		// ペア間のアイテム交換: 両ロボットのキャパシティ制約を満たしたまま合計価値が上がる交換を探す。
+		// 交換は2件の価値をロボット間で入れ替えるだけで2台の合計価値は変わらないため、この条件を満たす
+		// 交換はなく、合計価値を実際に上げるのは下の追加・追い出し置換だけである。
+		for i := range assignments {
+			for j := range assignments {
+				if i == j {
+					continue
+				}
+				a, b := assignments[i], assignments[j]
+				for oi := range a.orders {
+					for oj := range b.orders {
+						oa, ob := a.orders[oi], b.orders[oj]
+						newWeightA := a.weight - oa.Weight + ob.Weight
+						newWeightB := b.weight - ob.Weight + oa.Weight
+						if newWeightA > a.req.capacity || newWeightB > b.req.capacity {
+							continue
+						}
+						newValue := (a.value - oa.Value + ob.Value) + (b.value - ob.Value + oa.Value)
+						if newValue <= a.value+b.value {
+							continue
+						}
+						a.orders[oi], b.orders[oj] = ob, oa
+						a.weight, b.weight = newWeightA, newWeightB
+						a.value = a.value - oa.Value + ob.Value
+						b.value = b.value - ob.Value + oa.Value
+						improved = true
+					}
+				}
+			}
+		}
+
+		// 追い出し置換: 未割り当ての注文を、いずれかのロボットの積み荷1件と入れ替えて価値が上がるか試す。
+		for ui := 0; ui < len(unassigned); ui++ {
+			candidate := unassigned[ui]
+			swapped := false
+			for _, a := range assignments {
+				remaining := a.req.capacity - a.weight
+				if remaining >= candidate.Weight {
+					a.orders = append(a.orders, candidate)
+					a.weight += candidate.Weight
+					a.value += candidate.Value
+					unassigned = append(unassigned[:ui], unassigned[ui+1:]...)
+					ui--
+					swapped = true
+					improved = true
+					break
+				}
+				for oi, existing := range a.orders {
+					newWeight := a.weight - existing.Weight + candidate.Weight
+					if newWeight > a.req.capacity || candidate.Value <= existing.Value {
+						continue
+					}
+					a.orders[oi] = candidate
+					a.weight = newWeight
+					a.value = a.value - existing.Value + candidate.Value
+					unassigned[ui] = existing
+					swapped = true
+					improved = true
+					break
+				}
+				if swapped {
+					break
+				}
+			}
+		}
+
+		if !time.Now().Before(deadline) {
+			break
+		}
+	}
 }
 
 func (s *RobotService) GenerateDeliveryPlan(ctx context.Context, robotID string, capacity int) (*model.DeliveryPlan, error) {
@@ -32,7 +434,14 @@ func (s *RobotService) GenerateDeliveryPlan(ctx context.Context, robotID string,
 				log.Printf("Robot %s: found %d orders with status 'shipping'", robotID, len(orders))
 			}
 			
-			plan, err = selectOrdersForDeliveryOptimized(ctx, orders, robotID, capacity)
+			cfg := knapsackConfig{
+				epsilon:        s.epsilon,
+				priorityAlpha:  s.priorityAlpha,
+				deadlineBeta:   s.deadlineBeta,
+				urgencyWindow:  s.urgencyWindow,
+				mustShipWindow: s.mustShipWindow,
+			}
+			plan, err = selectOrdersForDeliveryOptimized(ctx, orders, robotID, capacity, cfg, time.Now())
 			if err != nil {
 				return err
 			}
@@ -42,7 +451,15 @@ func (s *RobotService) GenerateDeliveryPlan(ctx context.Context, robotID string,
 					orderIDs[i] = order.OrderID
 				}
 
-				if err := txStore.OrderRepo.UpdateStatuses(ctx, orderIDs, "delivering"); err != nil {
+				transitioned, err := txStore.OrderRepo.TransitionStatus(ctx, orderIDs, "shipping", "delivering", robotID)
+				if err != nil {
+					return err
+				}
+				plan.Orders = filterOrdersByID(plan.Orders, transitioned)
+				plan.TotalWeight, plan.TotalValue = sumWeightAndValue(plan.Orders)
+				orderIDs = transitioned
+
+				if err := txStore.OrderRepo.WriteDeliveryPlanEvent(ctx, robotID, orderIDs, plan.TotalValue); err != nil {
 					return err
 				}
 				// Only log for significant batches
@@ -60,14 +477,71 @@ func (s *RobotService) GenerateDeliveryPlan(ctx context.Context, robotID string,
 	return &plan, nil
 }
 
+// UpdateOrderStatus はロボットが配送結果(典型的には delivering → completed)を報告するための
+// 汎用エンドポイント。delivering からの遷移だけを許可し、それ以外は何も更新せず黙って無視する。
 func (s *RobotService) UpdateOrderStatus(ctx context.Context, orderID int64, newStatus string) error {
 	return utils.WithTimeout(ctx, func(ctx context.Context) error {
-		return s.store.OrderRepo.UpdateStatuses(ctx, []int64{orderID}, newStatus)
+		return s.store.ExecTx(ctx, func(txStore *repository.Store) error {
+			_, err := txStore.OrderRepo.TransitionStatus(ctx, []int64{orderID}, "delivering", newStatus, "")
+			return err
+		})
+	})
+}
+
+// maxCancelAttempts はロボットが同じ注文を持ち直せる上限。maxCancelAttempts 回目のキャンセルを受けた注文は
+// shipping へ差し戻さず failed に落とし、無限に再配達を試み続けるのを防ぐ。
+const maxCancelAttempts = 3
+
+// CancelDelivery はロボットが配送を中断した(バッテリー切れ、障害物、タイムアウト等)ときに呼ばれ、
+// 注文を delivering から shipping へ差し戻して次の GetShippingOrders の対象に戻す。
+// このキャンセルで cancel_count が maxCancelAttempts に達する場合(maxCancelAttempts 回目のキャンセル)は
+// shipping ではなく failed へ遷移させる。
+func (s *RobotService) CancelDelivery(ctx context.Context, orderID int64, robotID string) error {
+	return utils.WithTimeout(ctx, func(ctx context.Context) error {
+		return s.store.ExecTx(ctx, func(txStore *repository.Store) error {
+			cancelCount, err := txStore.OrderRepo.GetCancelCount(ctx, orderID)
+			if err != nil {
+				return err
+			}
+
+			to := "shipping"
+			if cancelCount+1 >= maxCancelAttempts {
+				to = "failed"
+			}
+
+			_, err = txStore.OrderRepo.TransitionStatus(ctx, []int64{orderID}, "delivering", to, robotID)
+			return err
+		})
+	})
+}
+
+// ForceResetStuckOrders は delivering のまま threshold 以上放置されている注文を shipping へ
+// 強制的に差し戻す。管理者向けエンドポイントから呼び出されることを想定している。
+func (s *RobotService) ForceResetStuckOrders(ctx context.Context, olderThan time.Duration) ([]int64, error) {
+	var reset []int64
+	err := utils.WithTimeout(ctx, func(ctx context.Context) error {
+		return s.store.ExecTx(ctx, func(txStore *repository.Store) error {
+			var err error
+			reset, err = txStore.OrderRepo.ForceResetStuckOrders(ctx, olderThan)
+			return err
+		})
 	})
+	return reset, err
+}
+
+// knapsackConfig は selectOrdersForDeliveryOptimized 以下が使う近似精度とSLA重み付けをまとめたもの。
+type knapsackConfig struct {
+	epsilon        float64
+	priorityAlpha  float64
+	deadlineBeta   float64
+	urgencyWindow  time.Duration
+	mustShipWindow time.Duration
 }
 
-// Highly optimized knapsack - uses greedy for large datasets, DP for smaller ones
-func selectOrdersForDeliveryOptimized(ctx context.Context, orders []model.Order, robotID string, robotCapacity int) (model.DeliveryPlan, error) {
+// Highly optimized knapsack - hard-inclusion pass for due/overdue orders, then an FPTAS for
+// large remaining datasets, exact DP for smaller ones. The optimizer ranks by the SLA-weighted
+// effective value V_eff, not raw Value, so higher priority / more urgent orders are preferred.
+func selectOrdersForDeliveryOptimized(ctx context.Context, orders []model.Order, robotID string, robotCapacity int, cfg knapsackConfig, now time.Time) (model.DeliveryPlan, error) {
 	n := len(orders)
 	if n == 0 {
 		return model.DeliveryPlan{
@@ -78,19 +552,302 @@ func selectOrdersForDeliveryOptimized(ctx context.Context, orders []model.Order,
 		}, nil
 	}
 
-	// Use greedy for large datasets or high capacity to avoid memory/time issues
-	if n > 500 || robotCapacity > 5000 {
-		return selectOrdersGreedy(orders, robotID, robotCapacity), nil
+	// 期限切れ、または must-ship window 以内の注文は、重量が許す限り先に確保してから
+	// 残りキャパシティだけを最適化にかける。
+	hard, rest, remainingCapacity := partitionHardInclusion(orders, now, cfg.mustShipWindow, robotCapacity)
+
+	optimized := model.DeliveryPlan{RobotID: robotID, Orders: []model.Order{}}
+	if len(rest) > 0 && remainingCapacity > 0 {
+		scores := computeEffectiveScores(rest, now, cfg.priorityAlpha, cfg.deadlineBeta, cfg.urgencyWindow)
+
+		// Exact 1D weight DP is only affordable while n * capacity stays small; beyond that
+		// fall back to the FPTAS, which guarantees at least (1-epsilon)*OPT instead of the
+		// unbounded worst case of plain value/weight greedy. Once n exceeds
+		// sqrt(epsilon*maxFPTASWidth) the width cap coarsens K and the bound loosens to
+		// (1 - n^2/maxFPTASWidth)*OPT.
+		var err error
+		if len(rest) > 500 || remainingCapacity > 5000 {
+			optimized, err = selectOrdersFPTAS(ctx, rest, scores, robotID, remainingCapacity, cfg.epsilon)
+		} else {
+			optimized, err = selectOrdersDP(ctx, rest, scores, robotID, remainingCapacity)
+		}
+		if err != nil {
+			return model.DeliveryPlan{}, err
+		}
 	}
 
-	// For smaller datasets, use optimized DP with early termination
-	return selectOrdersDP(ctx, orders, robotID, robotCapacity)
+	selected := append(append([]model.Order{}, hard...), optimized.Orders...)
+	totalWeight, totalValue := sumWeightAndValue(selected)
+
+	return model.DeliveryPlan{
+		RobotID:     robotID,
+		TotalWeight: totalWeight,
+		TotalValue:  totalValue,
+		Orders:      selected,
+	}, nil
+}
+
+// effectiveValueScore は V_eff = Value*(1+alpha*priority) + beta*urgency(deadline_at, now) を
+// 整数スコアとして丸めたもの。knapsack DP/FPTAS はこのスコアを最適化目的として使う。
+func effectiveValueScore(order model.Order, now time.Time, alpha, beta float64, urgencyWindow time.Duration) int {
+	score := float64(order.Value)*(1+alpha*float64(order.Priority)) + beta*urgencyFactor(order.DeadlineAt, now, urgencyWindow)
+	if score < 0 {
+		score = 0
+	}
+	return int(math.Round(score))
+}
+
+// urgencyFactor は締切までの残り時間が urgencyWindow に対してどれだけ短いかを [0,1] で表す。
+// 締切なしなら0、締切を過ぎていれば1、それ以外は max(0, 1 - remaining/window)^2 で急激に立ち上がる。
+func urgencyFactor(deadline sql.NullTime, now time.Time, urgencyWindow time.Duration) float64 {
+	if !deadline.Valid || urgencyWindow <= 0 {
+		return 0
+	}
+	remaining := deadline.Time.Sub(now)
+	if remaining <= 0 {
+		return 1
+	}
+	ratio := float64(remaining) / float64(urgencyWindow)
+	if ratio >= 1 {
+		return 0
+	}
+	u := 1 - ratio
+	return u * u
+}
+
+// computeEffectiveScores は orders と同じ並びで各注文の effectiveValueScore を計算する。
+func computeEffectiveScores(orders []model.Order, now time.Time, alpha, beta float64, urgencyWindow time.Duration) []int {
+	scores := make([]int, len(orders))
+	for i, order := range orders {
+		scores[i] = effectiveValueScore(order, now, alpha, beta, urgencyWindow)
+	}
+	return scores
+}
+
+// partitionHardInclusion は締切を過ぎている、または mustShipWindow 以内に迫っている注文を
+// value/weight比の高い順に、キャパシティが許す限り強制的に確保する。収まらなかった分は
+// 通常の最適化対象(rest)に戻すため、キャパシティ制約は常に守られる。
+func partitionHardInclusion(orders []model.Order, now time.Time, mustShipWindow time.Duration, capacity int) (hard []model.Order, rest []model.Order, remainingCapacity int) {
+	remainingCapacity = capacity
+
+	var candidates []model.Order
+	for _, order := range orders {
+		if order.DeadlineAt.Valid && order.DeadlineAt.Time.Sub(now) <= mustShipWindow {
+			candidates = append(candidates, order)
+		} else {
+			rest = append(rest, order)
+		}
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		ratioI := float64(candidates[i].Value) / float64(candidates[i].Weight)
+		ratioJ := float64(candidates[j].Value) / float64(candidates[j].Weight)
+		return ratioI > ratioJ
+	})
+
+	for _, order := range candidates {
+		if order.Weight <= remainingCapacity {
+			hard = append(hard, order)
+			remainingCapacity -= order.Weight
+		} else {
+			rest = append(rest, order)
+		}
+	}
+
+	return hard, rest, remainingCapacity
+}
+
+// fptasUnreachable は FPTAS のDPで「そのスケーリング済み価値はまだ到達不可能」を表す番兵値。
+const fptasUnreachable = 1<<31 - 1
+
+// knapsackValueDP は orders[lo:hi) だけを使い、スケーリング済み価値 v (0..maxV) をちょうど
+// 達成するのに必要な最小重量を求める1次元DP。selectOrdersFPTAS とその分割統治バックトラックの
+// 両方から呼ばれる共通ルーチンで、呼び出しごとに O(maxV) しか確保しない。
+func knapsackValueDP(ctx context.Context, orders []model.Order, scaledValues []int, lo, hi, maxV int) ([]int, error) {
+	dp := make([]int, maxV+1)
+	for v := 1; v <= maxV; v++ {
+		dp[v] = fptasUnreachable
+	}
+
+	for i := lo; i < hi; i++ {
+		if (i-lo)%50 == 0 {
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			default:
+			}
+		}
+
+		sv := scaledValues[i]
+		weight := orders[i].Weight
+		for v := maxV; v >= sv; v-- {
+			if dp[v-sv] == fptasUnreachable {
+				continue
+			}
+			if candidate := dp[v-sv] + weight; candidate < dp[v] {
+				dp[v] = candidate
+			}
+		}
+	}
+
+	return dp, nil
+}
+
+// reconstructFPTASSelection は orders[lo:hi) の中からスケーリング済み価値の合計がちょうど
+// target になる部分集合を求める。selectOrdersFPTAS が n×V の bool テーブルを丸ごと保持する代わりに、
+// Hirschberg法と同じ発想で区間を半分に割り、左右それぞれの O(target) の価値DPから最適な分割点
+// v1 を探して再帰する。各再帰レベルのメモリは O(target) で済み、深さは O(log n) にしかならない。
+func reconstructFPTASSelection(ctx context.Context, orders []model.Order, scaledValues []int, lo, hi, target int) ([]model.Order, error) {
+	if target <= 0 || lo >= hi {
+		return nil, nil
+	}
+	if hi-lo == 1 {
+		if scaledValues[lo] == target {
+			return []model.Order{orders[lo]}, nil
+		}
+		return nil, nil
+	}
+
+	mid := (lo + hi) / 2
+	left, err := knapsackValueDP(ctx, orders, scaledValues, lo, mid, target)
+	if err != nil {
+		return nil, err
+	}
+	right, err := knapsackValueDP(ctx, orders, scaledValues, mid, hi, target)
+	if err != nil {
+		return nil, err
+	}
+
+	bestWeight := fptasUnreachable
+	bestV1 := -1
+	for v1 := 0; v1 <= target; v1++ {
+		if left[v1] == fptasUnreachable || right[target-v1] == fptasUnreachable {
+			continue
+		}
+		if w := left[v1] + right[target-v1]; w < bestWeight {
+			bestWeight = w
+			bestV1 = v1
+		}
+	}
+	if bestV1 < 0 {
+		return nil, nil
+	}
+
+	leftOrders, err := reconstructFPTASSelection(ctx, orders, scaledValues, lo, mid, bestV1)
+	if err != nil {
+		return nil, err
+	}
+	rightOrders, err := reconstructFPTASSelection(ctx, orders, scaledValues, mid, hi, target-bestV1)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(leftOrders, rightOrders...), nil
+}
+
+// selectOrdersFPTAS は全注文を対象に、scores をスケーリングした疑似多項式DPにより
+// (1-epsilon)・OPT を保証する近似解を求める。ただし n > sqrt(epsilon・maxFPTASWidth) では K が
+// maxFPTASWidth によって粗くなり、保証は (1 - n²/maxFPTASWidth)・OPT まで弱まる。
+// orders と scores は同じ並びであることが前提。
+// バックトラックは n×V の bool テーブルを保持せず reconstructFPTASSelection の分割統治に委ねる。
+func selectOrdersFPTAS(ctx context.Context, orders []model.Order, scores []int, robotID string, robotCapacity int, epsilon float64) (model.DeliveryPlan, error) {
+	n := len(orders)
+	if epsilon <= 0 {
+		epsilon = defaultKnapsackEpsilon
+	}
+
+	scoreMax := 0
+	for _, score := range scores {
+		if score > scoreMax {
+			scoreMax = score
+		}
+	}
+	if scoreMax == 0 {
+		return model.DeliveryPlan{
+			RobotID:     robotID,
+			TotalWeight: 0,
+			TotalValue:  0,
+			Orders:      []model.Order{},
+		}, nil
+	}
+
+	k := epsilon * float64(scoreMax) / float64(n)
+	if k < 1 {
+		k = 1
+	}
+
+	// Smallest k that keeps the DP width within maxFPTASWidth (sum(floor(score_i/k)) <= n*scoreMax/k).
+	if widthBudgetK := float64(n) * float64(scoreMax) / float64(maxFPTASWidth); k < widthBudgetK {
+		k = widthBudgetK
+	}
+
+	scaledValues := make([]int, n)
+	maxScaledValue := 0
+	for i, score := range scores {
+		scaledValues[i] = int(float64(score) / k)
+		maxScaledValue += scaledValues[i]
+	}
+
+	// dp[v] = そのスケーリング済み価値 v をちょうど達成するのに必要な最小重量(達成不可能なら番兵値)。
+	// capacity を超える組み合わせは候補から外す。
+	dp := make([]int, maxScaledValue+1)
+	for v := 1; v <= maxScaledValue; v++ {
+		dp[v] = fptasUnreachable
+	}
+	for i := 0; i < n; i++ {
+		if i%50 == 0 {
+			select {
+			case <-ctx.Done():
+				return model.DeliveryPlan{}, ctx.Err()
+			default:
+			}
+		}
+
+		sv := scaledValues[i]
+		weight := orders[i].Weight
+		for v := maxScaledValue; v >= sv; v-- {
+			if dp[v-sv] == fptasUnreachable {
+				continue
+			}
+			if candidate := dp[v-sv] + weight; candidate < dp[v] && candidate <= robotCapacity {
+				dp[v] = candidate
+			}
+		}
+	}
+
+	bestV := 0
+	for v := maxScaledValue; v >= 0; v-- {
+		if dp[v] <= robotCapacity {
+			bestV = v
+			break
+		}
+	}
+
+	selectedOrders, err := reconstructFPTASSelection(ctx, orders, scaledValues, 0, n, bestV)
+	if err != nil {
+		return model.DeliveryPlan{}, err
+	}
+	if selectedOrders == nil {
+		selectedOrders = []model.Order{}
+	}
+
+	totalWeight, totalValue := sumWeightAndValue(selectedOrders)
+
+	return model.DeliveryPlan{
+		RobotID:     robotID,
+		TotalWeight: totalWeight,
+		TotalValue:  totalValue,
+		Orders:      selectedOrders,
+	}, nil
 }
 
-// Optimized DP implementation with context checking and memory optimization
-func selectOrdersDP(ctx context.Context, orders []model.Order, robotID string, robotCapacity int) (model.DeliveryPlan, error) {
+// Optimized DP implementation with context checking and memory optimization.
+// The DP optimizes for the sum of scores (the SLA-weighted effective value), while the
+// returned TotalValue/TotalWeight reflect the actual selected orders. orders and scores
+// must be the same length and in the same order.
+func selectOrdersDP(ctx context.Context, orders []model.Order, scores []int, robotID string, robotCapacity int) (model.DeliveryPlan, error) {
 	n := len(orders)
-	
+
 	// Use 1D DP array for memory optimization
 	dp := make([]int, robotCapacity+1)
 	keep := make([][]bool, n+1)
@@ -101,7 +858,8 @@ func selectOrdersDP(ctx context.Context, orders []model.Order, robotID string, r
 	// Fill DP table with optimizations
 	for i := 1; i <= n; i++ {
 		order := orders[i-1]
-		
+		score := scores[i-1]
+
 		// Check context cancellation every 50 iterations
 		if i%50 == 0 {
 			select {
@@ -113,9 +871,9 @@ func selectOrdersDP(ctx context.Context, orders []model.Order, robotID string, r
 
 		// Process in reverse order to avoid overwriting
 		for w := robotCapacity; w >= order.Weight; w-- {
-			includeValue := dp[w-order.Weight] + order.Value
-			if includeValue > dp[w] {
-				dp[w] = includeValue
+			includeScore := dp[w-order.Weight] + score
+			if includeScore > dp[w] {
+				dp[w] = includeScore
 				keep[i][w] = true
 			}
 		}
@@ -123,64 +881,23 @@ func selectOrdersDP(ctx context.Context, orders []model.Order, robotID string, r
 
 	// Backtrack to find selected orders
 	selectedOrders := make([]model.Order, 0)
-	totalWeight := 0
 	w := robotCapacity
-	
+
 	for i := n; i > 0 && w > 0; i-- {
 		if keep[i][w] {
 			order := orders[i-1]
 			selectedOrders = append(selectedOrders, order)
 			w -= order.Weight
-			totalWeight += order.Weight
 		}
 	}
 
+	totalWeight, totalValue := sumWeightAndValue(selectedOrders)
+
 	return model.DeliveryPlan{
 		RobotID:     robotID,
 		TotalWeight: totalWeight,
-		TotalValue:  dp[robotCapacity],
+		TotalValue:  totalValue,
 		Orders:      selectedOrders,
 	}, nil
 }
 
-// Enhanced greedy approach with better sorting
-func selectOrdersGreedy(orders []model.Order, robotID string, robotCapacity int) model.DeliveryPlan {
-	if len(orders) == 0 {
-		return model.DeliveryPlan{
-			RobotID:     robotID,
-			TotalWeight: 0,
-			TotalValue:  0,
-			Orders:      []model.Order{},
-		}
-	}
-
-	// Create a copy to avoid modifying original slice
-	ordersCopy := make([]model.Order, len(orders))
-	copy(ordersCopy, orders)
-
-	// Sort by value/weight ratio using Go's built-in sort (much faster)
-	sort.Slice(ordersCopy, func(i, j int) bool {
-		ratio1 := float64(ordersCopy[i].Value) / float64(ordersCopy[i].Weight)
-		ratio2 := float64(ordersCopy[j].Value) / float64(ordersCopy[j].Weight)
-		return ratio2 < ratio1 // Descending order
-	})
-
-	selectedOrders := make([]model.Order, 0)
-	totalWeight := 0
-	totalValue := 0
-
-	for _, order := range ordersCopy {
-		if totalWeight+order.Weight <= robotCapacity {
-			selectedOrders = append(selectedOrders, order)
-			totalWeight += order.Weight
-			totalValue += order.Value
-		}
-	}
-
-	return model.DeliveryPlan{
-		RobotID:     robotID,
-		TotalWeight: totalWeight,
-		TotalValue:  totalValue,
-		Orders:      selectedOrders,
-	}
-}
\ No newline at end of file
@@ -0,0 +1,220 @@
+package service
+
+import (
+	"backend/internal/model"
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+)
+
+func TestSelectOrdersFPTAS_MeetsApproximationBound(t *testing.T) {
+	orders := []model.Order{
+		{OrderID: 1, Weight: 10, Value: 60},
+		{OrderID: 2, Weight: 20, Value: 100},
+		{OrderID: 3, Weight: 30, Value: 120},
+		{OrderID: 4, Weight: 15, Value: 70},
+		{OrderID: 5, Weight: 25, Value: 90},
+	}
+	const capacity = 50
+	const epsilon = 0.1
+	scores := make([]int, len(orders))
+	for i, order := range orders {
+		scores[i] = order.Value
+	}
+
+	exact, err := selectOrdersDP(context.Background(), orders, scores, "robot-exact", capacity)
+	if err != nil {
+		t.Fatalf("selectOrdersDP returned error: %v", err)
+	}
+
+	approx, err := selectOrdersFPTAS(context.Background(), orders, scores, "robot-approx", capacity, epsilon)
+	if err != nil {
+		t.Fatalf("selectOrdersFPTAS returned error: %v", err)
+	}
+
+	if approx.TotalWeight > capacity {
+		t.Fatalf("FPTAS plan exceeds capacity: weight=%d capacity=%d", approx.TotalWeight, capacity)
+	}
+
+	minAcceptable := float64(exact.TotalValue) * (1 - epsilon)
+	if float64(approx.TotalValue) < minAcceptable {
+		t.Fatalf("FPTAS value %d is below the (1-epsilon)*OPT bound %.2f (OPT=%d)",
+			approx.TotalValue, minAcceptable, exact.TotalValue)
+	}
+}
+
+// TestSelectOrdersForDeliveryOptimized_LargeNKeepsApproximationBound reproduces a regression
+// where the FPTAS path truncated its input to the top maxFPTASItems orders by V_eff/weight
+// ratio before solving, then greedily filled leftover capacity with whatever ratio-ranked
+// orders it had discarded. That truncation is the classic ratio-greedy counterexample: many
+// low-weight/low-value orders can out-rank a single much higher-value order, which then never
+// reaches the DP at all. 800 weight-1/value-2 orders plus one weight-1000/value-1900 order
+// under capacity 1000 has OPT=1900 (the single large order alone), but the truncating version
+// returned 1600 (all 800 small orders, with the large one discarded before the DP ever saw it).
+func TestSelectOrdersForDeliveryOptimized_LargeNKeepsApproximationBound(t *testing.T) {
+	const capacity = 1000
+	const epsilon = 0.1
+
+	orders := make([]model.Order, 0, 801)
+	for i := 0; i < 800; i++ {
+		orders = append(orders, model.Order{OrderID: int64(i + 1), Weight: 1, Value: 2})
+	}
+	orders = append(orders, model.Order{OrderID: 801, Weight: 1000, Value: 1900})
+
+	cfg := knapsackConfig{epsilon: epsilon, urgencyWindow: defaultUrgencyWindow, mustShipWindow: defaultMustShipWindow}
+	plan, err := selectOrdersForDeliveryOptimized(context.Background(), orders, "robot-large-n", capacity, cfg, time.Now())
+	if err != nil {
+		t.Fatalf("selectOrdersForDeliveryOptimized returned error: %v", err)
+	}
+
+	if plan.TotalWeight > capacity {
+		t.Fatalf("plan exceeds capacity: weight=%d capacity=%d", plan.TotalWeight, capacity)
+	}
+
+	const opt = 1900
+	minAcceptable := float64(opt) * (1 - epsilon)
+	if float64(plan.TotalValue) < minAcceptable {
+		t.Fatalf("plan value %d is below the (1-epsilon)*OPT bound %.2f (OPT=%d)", plan.TotalValue, minAcceptable, opt)
+	}
+}
+
+func TestSelectOrdersFPTAS_EmptyOrders(t *testing.T) {
+	plan, err := selectOrdersFPTAS(context.Background(), nil, nil, "robot-empty", 100, 0.1)
+	if err != nil {
+		t.Fatalf("selectOrdersFPTAS returned error: %v", err)
+	}
+	if plan.TotalValue != 0 || len(plan.Orders) != 0 {
+		t.Fatalf("expected empty plan, got %+v", plan)
+	}
+}
+
+func TestEffectiveValueScore_DeadlineOrdersAheadOfEqualValue(t *testing.T) {
+	now := time.Now()
+	const alpha, beta = 0.1, 50.0
+	const urgencyWindow = 24 * time.Hour
+
+	soon := model.Order{Weight: 10, Value: 100, DeadlineAt: sql.NullTime{Time: now.Add(1 * time.Hour), Valid: true}}
+	later := model.Order{Weight: 10, Value: 100, DeadlineAt: sql.NullTime{Time: now.Add(23 * time.Hour), Valid: true}}
+
+	scoreSoon := effectiveValueScore(soon, now, alpha, beta, urgencyWindow)
+	scoreLater := effectiveValueScore(later, now, alpha, beta, urgencyWindow)
+
+	if scoreSoon <= scoreLater {
+		t.Fatalf("expected order with closer deadline to score higher: soon=%d later=%d", scoreSoon, scoreLater)
+	}
+}
+
+func TestSolveMultipleKnapsack_NeverExceedsCapacity(t *testing.T) {
+	orders := []model.Order{
+		{OrderID: 1, Weight: 10, Value: 100},
+		{OrderID: 2, Weight: 20, Value: 150},
+		{OrderID: 3, Weight: 15, Value: 90},
+		{OrderID: 4, Weight: 25, Value: 200},
+		{OrderID: 5, Weight: 5, Value: 40},
+	}
+	batch := []*fleetRequest{
+		{robotID: "robot-a", capacity: 25, priority: 0},
+		{robotID: "robot-b", capacity: 25, priority: 0},
+	}
+
+	assignments := solveMultipleKnapsack(orders, batch)
+
+	if len(assignments) != len(batch) {
+		t.Fatalf("expected %d assignments, got %d", len(batch), len(assignments))
+	}
+	seen := make(map[int64]bool)
+	for _, a := range assignments {
+		if a.weight > a.req.capacity {
+			t.Fatalf("robot %s assignment exceeds capacity: weight=%d capacity=%d", a.req.robotID, a.weight, a.req.capacity)
+		}
+		for _, o := range a.orders {
+			if seen[o.OrderID] {
+				t.Fatalf("order %d assigned to more than one robot", o.OrderID)
+			}
+			seen[o.OrderID] = true
+		}
+	}
+}
+
+func TestSolveMultipleKnapsack_HigherPriorityRobotFillsFirst(t *testing.T) {
+	// A single order that only one robot can take: both have room, but the higher-priority
+	// robot should be preferred by bestFleetCandidate even though both requests start empty.
+	orders := []model.Order{
+		{OrderID: 1, Weight: 10, Value: 100},
+	}
+	batch := []*fleetRequest{
+		{robotID: "robot-low", capacity: 10, priority: 0},
+		{robotID: "robot-high", capacity: 10, priority: 5},
+	}
+
+	assignments := solveMultipleKnapsack(orders, batch)
+
+	var highAssignment, lowAssignment *fleetAssignment
+	for _, a := range assignments {
+		switch a.req.robotID {
+		case "robot-high":
+			highAssignment = a
+		case "robot-low":
+			lowAssignment = a
+		}
+	}
+	if len(highAssignment.orders) != 1 {
+		t.Fatalf("expected the higher-priority robot to receive the order, got %d orders", len(highAssignment.orders))
+	}
+	if len(lowAssignment.orders) != 0 {
+		t.Fatalf("expected the lower-priority robot to receive nothing, got %d orders", len(lowAssignment.orders))
+	}
+}
+
+func TestImproveFleetAssignments_EvictsLowerValueOrderForUnassigned(t *testing.T) {
+	// robot-a is already full with a low-value order; a lighter, higher-value unassigned order
+	// should evict it once the local-search pass runs.
+	low := model.Order{OrderID: 1, Weight: 15, Value: 60}
+	pendingHighValue := model.Order{OrderID: 2, Weight: 5, Value: 100}
+
+	assignments := []*fleetAssignment{
+		{req: &fleetRequest{robotID: "robot-a", capacity: 15}, orders: []model.Order{low}, weight: 15, value: 60},
+	}
+
+	improveFleetAssignments(assignments, []model.Order{low, pendingHighValue}, time.Now().Add(50*time.Millisecond))
+
+	a := assignments[0]
+	if a.weight > a.req.capacity {
+		t.Fatalf("robot-a exceeds capacity after improvement: weight=%d capacity=%d", a.weight, a.req.capacity)
+	}
+	if len(a.orders) != 1 || a.orders[0].OrderID != pendingHighValue.OrderID {
+		t.Fatalf("expected the pending higher-value order to evict the low-value one, got %+v", a.orders)
+	}
+	if a.value != pendingHighValue.Value {
+		t.Fatalf("expected assignment value to become %d, got %d", pendingHighValue.Value, a.value)
+	}
+}
+
+func TestPartitionHardInclusion_NeverExceedsCapacity(t *testing.T) {
+	now := time.Now()
+	const capacity = 30
+	const mustShipWindow = 1 * time.Hour
+
+	orders := []model.Order{
+		{OrderID: 1, Weight: 20, Value: 50, DeadlineAt: sql.NullTime{Time: now.Add(-1 * time.Minute), Valid: true}},
+		{OrderID: 2, Weight: 20, Value: 50, DeadlineAt: sql.NullTime{Time: now.Add(10 * time.Minute), Valid: true}},
+		{OrderID: 3, Weight: 5, Value: 10},
+	}
+
+	hard, rest, remainingCapacity := partitionHardInclusion(orders, now, mustShipWindow, capacity)
+
+	hardWeight := 0
+	for _, order := range hard {
+		hardWeight += order.Weight
+	}
+	if hardWeight > capacity {
+		t.Fatalf("hard-inclusion weight %d exceeds capacity %d", hardWeight, capacity)
+	}
+	if remainingCapacity != capacity-hardWeight {
+		t.Fatalf("remainingCapacity %d does not match capacity-hardWeight %d", remainingCapacity, capacity-hardWeight)
+	}
+	if len(hard)+len(rest) != len(orders) {
+		t.Fatalf("expected every order to land in hard or rest, got hard=%d rest=%d total=%d", len(hard), len(rest), len(orders))
+	}
+}